@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/handler"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	backendLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "smee",
+		Subsystem: "backend",
+		Name:      "lookups_total",
+		Help:      "Total number of lookups per backend, partitioned by result (hit, miss, error).",
+	}, []string{"backend", "result"})
+
+	backendLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "smee",
+		Subsystem: "backend",
+		Name:      "lookup_duration_seconds",
+		Help:      "Duration of a single backend lookup.",
+	}, []string{"backend"})
+)
+
+// backendEntry is one element of the ordered backend chain. readOnly
+// backends are still queried for netboot data, but are never treated as the
+// authoritative backend for anything that would otherwise write back to the
+// source (reserved for future write-capable backends).
+type backendEntry struct {
+	name          string
+	reader        handler.BackendReader
+	readOnly      bool
+	hardErrorStop bool
+}
+
+// compositeBackend queries an ordered list of backends and returns the
+// result from the first one that has an answer, falling through to the
+// next backend on a not-found. This lets operators layer sources of
+// hardware truth, e.g. a handful of machines pinned in a file backend on
+// top of the bulk of a fleet living in Tinkerbell CRDs.
+type compositeBackend struct {
+	log      logr.Logger
+	backends []backendEntry
+}
+
+// buildBackendReader parses --backend-order and assembles the ordered,
+// instrumented composite backend reader used by both the DHCP handler and
+// the HTTP iPXE script handler.
+func (c *config) buildBackendReader(ctx context.Context, log logr.Logger) (handler.BackendReader, error) {
+	order := splitAndTrim(c.backends.order)
+	if len(order) == 0 {
+		order = []string{"kubernetes"}
+	}
+
+	var entries []backendEntry
+	seen := map[string]bool{}
+	for _, name := range order {
+		if seen[name] {
+			return nil, fmt.Errorf("backend %q listed more than once in -backend-order", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "file":
+			if !c.backends.file.Enabled {
+				continue
+			}
+			b, err := c.backends.file.Backend(ctx, log)
+			if err != nil {
+				return nil, fmt.Errorf("failed to run file backend: %w", err)
+			}
+			entries = append(entries, backendEntry{name: name, reader: b, readOnly: c.backends.file.ReadOnly, hardErrorStop: c.backends.fileHardError})
+		case "kubernetes", "kube":
+			if !c.backends.kubernetes.Enabled {
+				continue
+			}
+			b, err := c.backends.kubernetes.Backend(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to run kubernetes backend: %w", err)
+			}
+			entries = append(entries, backendEntry{name: "kubernetes", reader: b, readOnly: c.backends.kubernetes.ReadOnly, hardErrorStop: c.backends.kubeHardError})
+		default:
+			return nil, fmt.Errorf("unknown backend %q in -backend-order, must be one of: file, kubernetes", name)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("no backends enabled, enable at least one of -backend-file-enabled or -backend-kube-enabled")
+	}
+
+	return &compositeBackend{log: log, backends: entries}, nil
+}
+
+func (cb *compositeBackend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return cb.lookup(ctx, "GetByMac", func(ctx context.Context, r handler.BackendReader) (*data.DHCP, *data.Netboot, error) {
+		return r.GetByMac(ctx, mac)
+	})
+}
+
+func (cb *compositeBackend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return cb.lookup(ctx, "GetByIP", func(ctx context.Context, r handler.BackendReader) (*data.DHCP, *data.Netboot, error) {
+		return r.GetByIP(ctx, ip)
+	})
+}
+
+func (cb *compositeBackend) lookup(ctx context.Context, op string, call func(context.Context, handler.BackendReader) (*data.DHCP, *data.Netboot, error)) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer("github.com/tinkerbell/smee")
+	ctx, span := tracer.Start(ctx, "backend.composite."+op)
+	defer span.End()
+
+	var lastErr error
+	for _, b := range cb.backends {
+		start := time.Now()
+		dhcp, netboot, err := call(ctx, b.reader)
+		backendLookupDuration.WithLabelValues(b.name).Observe(time.Since(start).Seconds())
+		span.SetAttributes(attribute.String("backend.name", b.name))
+
+		switch {
+		case err == nil:
+			backendLookups.WithLabelValues(b.name, "hit").Inc()
+			span.SetAttributes(attribute.String("backend.answered_by", b.name))
+
+			return dhcp, netboot, nil
+		case isNotFound(err):
+			backendLookups.WithLabelValues(b.name, "miss").Inc()
+			cb.log.V(1).Info("backend miss, falling through", "backend", b.name, "op", op)
+			lastErr = err
+
+			continue
+		default:
+			backendLookups.WithLabelValues(b.name, "error").Inc()
+			if b.hardErrorStop {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+
+				return nil, nil, fmt.Errorf("backend %q: %w", b.name, err)
+			}
+			cb.log.Error(err, "backend error, falling through", "backend", b.name, "op", op)
+			lastErr = err
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no backends configured")
+	}
+	span.RecordError(lastErr)
+	span.SetStatus(codes.Error, lastErr.Error())
+
+	return nil, nil, fmt.Errorf("%s: not found in any backend: %w", op, lastErr)
+}
+
+// backendSynced is implemented by backends that have an initial-sync phase
+// (e.g. a Kubernetes informer's HasSynced, or a file watcher's initial
+// load) that must complete before they can answer lookups correctly.
+// Backends that answer synchronously from the start don't need it.
+type backendSynced interface {
+	HasSynced() bool
+}
+
+// backendReadiness reports whether every backend behind br that implements
+// backendSynced has completed its initial sync. Backends that don't implement
+// backendSynced are assumed ready immediately.
+func backendReadiness(br handler.BackendReader) error {
+	cb, ok := br.(*compositeBackend)
+	if !ok {
+		if s, ok := br.(backendSynced); ok && !s.HasSynced() {
+			return errors.New("backend has not completed its initial sync")
+		}
+
+		return nil
+	}
+
+	for _, b := range cb.backends {
+		s, ok := b.reader.(backendSynced)
+		if ok && !s.HasSynced() {
+			return fmt.Errorf("backend %q has not completed its initial sync", b.name)
+		}
+	}
+
+	return nil
+}
+
+// isNotFound reports whether err represents a "no record" answer from a
+// backend, as opposed to a hard failure (connection refused, informer not
+// synced, etc). Backends in this tree surface this with a "not found"
+// substring; swap for errors.Is against a sentinel once backends export one.
+// Errors that also look like an authz failure (e.g. a Kubernetes RBAC
+// denial whose message happens to mention a "not found" namespace) are
+// deliberately excluded so they surface as the hard error they are instead
+// of being silently treated as a soft miss.
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "forbidden") || strings.Contains(msg, "unauthorized") || strings.Contains(msg, "denied") {
+		return false
+	}
+
+	return strings.Contains(msg, "not found")
+}
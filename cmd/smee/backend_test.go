@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIsNotFound(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"file backend missing entry", errors.New("no entry found for mac 00:00:00:00:00:00"), true},
+		{"kube informer not found", errors.New(`hardware.tinkerbell.org "00:00:00:00:00:00" not found`), true},
+		{"kube rbac denial mentioning a missing namespace", errors.New(`secrets is forbidden: User "system:serviceaccount:default:smee" cannot get resource "secrets" in namespace "not-found-ns"`), false},
+		{"kube unauthorized", errors.New("Unauthorized"), false},
+		{"connection refused", errors.New("dial tcp 10.0.0.1:6443: connect: connection refused"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isNotFound(c.err); got != c.want {
+				t.Errorf("isNotFound(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp"
+	"golang.org/x/sys/unix"
+)
+
+// dhcpMode selects how the DHCP subsystem behaves on the wire.
+type dhcpMode string
+
+const (
+	// modeReservation is the default: Smee is the only DHCP server on the
+	// segment and assigns leases itself.
+	modeReservation dhcpMode = "reservation"
+	// modeProxy runs Smee as an RFC 4578 ProxyDHCP server alongside an
+	// existing site DHCP server. Smee never assigns addresses; it only
+	// answers PXE/iPXE clients with boot options.
+	modeProxy dhcpMode = "proxy"
+	// modeAuto binds both :67 and the dedicated ProxyDHCP port (:4011 by
+	// default, see -dhcp-proxy-addr): the :4011 listener always behaves
+	// like modeProxy (PXE-only, no address assignment), while the :67
+	// listener behaves like modeReservation for a fresh DHCPDISCOVER but
+	// falls back to proxy-only behavior for any packet that already
+	// carries a client IP address (ciaddr), i.e. a renewal/rebind of a
+	// lease the site DHCP server, not Smee, granted.
+	modeAuto dhcpMode = "auto"
+)
+
+func parseDHCPMode(s string) (dhcpMode, error) {
+	switch dhcpMode(s) {
+	case modeReservation, modeProxy, modeAuto:
+		return dhcpMode(s), nil
+	default:
+		return "", fmt.Errorf("invalid -dhcp-mode %q, must be one of: reservation, proxy, auto", s)
+	}
+}
+
+// proxyDHCPHandler wraps a dhcp.Handler and only forwards packets that are
+// DHCPDISCOVER/DHCPREQUEST carrying a PXE client identifier (option 60
+// "PXEClient") or a client system architecture (option 93). Everything else
+// -- in particular, ordinary DHCPDISCOVER/REQUEST from non-PXE clients that
+// the site DHCP server is responsible for -- is dropped on the floor.
+//
+// Note this only filters which packets reach the wrapped reservation.Handler
+// -- it does not change what that handler does once a packet gets through.
+// Fully degrading the lease lookup itself to "MAC known in the backend ⇒
+// serve netboot options, no address required" is a change to
+// reservation.Handler's lease-assignment path in the vendored
+// github.com/tinkerbell/dhcp module, outside what cmd/smee can adapt; in
+// proxy/auto-proxy mode the handler's existing behavior for a hardware
+// record with no assignable address (e.g. any error returned while
+// resolving an address) still applies here.
+type proxyDHCPHandler struct {
+	next dhcp.Handler
+	log  logr.Logger
+}
+
+func (p *proxyDHCPHandler) Handle(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+	if m.MessageType() != dhcpv4.MessageTypeDiscover && m.MessageType() != dhcpv4.MessageTypeRequest {
+		return
+	}
+	if !isPXEClient(m) {
+		p.log.V(1).Info("ignoring non-pxe dhcp packet in proxy mode", "mac", m.ClientHWAddr.String())
+
+		return
+	}
+	p.next.Handle(conn, peer, m)
+}
+
+// isPXEClient reports whether m identifies itself as a PXE/iPXE client via
+// option 60 (vendor class identifier) or carries option 93 (client system
+// architecture), per RFC 4578.
+func isPXEClient(m *dhcpv4.DHCPv4) bool {
+	if vc := m.GetOneOption(dhcpv4.OptionClassIdentifier); vc != nil {
+		if s := string(vc); len(s) >= 9 && s[:9] == "PXEClient" {
+			return true
+		}
+	}
+
+	return m.GetOneOption(dhcpv4.OptionClientSystemArchitectureType) != nil
+}
+
+// autoDHCPHandler implements the :67 side of modeAuto: a fresh
+// DHCPDISCOVER/REQUEST (no ciaddr set) is handled as a normal reservation
+// lease, while a renewal/rebind that already carries a ciaddr -- meaning
+// some other server, not Smee, granted the lease -- is routed through the
+// PXE-only proxy path instead, same as modeProxy.
+type autoDHCPHandler struct {
+	proxy dhcp.Handler
+	full  dhcp.Handler
+}
+
+func (a *autoDHCPHandler) Handle(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+	if hasClientIPAddr(m) {
+		a.proxy.Handle(conn, peer, m)
+
+		return
+	}
+	a.full.Handle(conn, peer, m)
+}
+
+// hasClientIPAddr reports whether m already carries a non-zero ciaddr,
+// i.e. the client already holds a lease (from a DHCP server that may or
+// may not be Smee) and is renewing/rebinding it rather than discovering
+// a fresh address.
+func hasClientIPAddr(m *dhcpv4.DHCPv4) bool {
+	return m.ClientIPAddr != nil && !m.ClientIPAddr.IsUnspecified()
+}
+
+// wrapForMode adapts dh to the configured dhcp mode. In proxy mode the
+// handler is wrapped so only PXE clients get a response; everything else
+// (lease assignment, non-PXE clients) is left to the site DHCP server. In
+// auto mode, the :67 listener only falls back to that same proxy-only
+// behavior for requests that already carry evidence of a lease granted
+// elsewhere (see autoDHCPHandler); the dedicated :4011 ProxyDHCP listener
+// set up in main is always proxy-only regardless of mode.
+func wrapForMode(mode dhcpMode, log logr.Logger, dh dhcp.Handler) dhcp.Handler {
+	proxy := &proxyDHCPHandler{next: dh, log: log}
+	switch mode {
+	case modeProxy:
+		return proxy
+	case modeAuto:
+		return &autoDHCPHandler{proxy: proxy, full: dh}
+	default:
+		return dh
+	}
+}
+
+// reusableUDPConn opens a UDP socket with SO_REUSEADDR and SO_REUSEPORT set,
+// so Smee can bind :67 (or :4011) alongside a site DHCP server that is
+// already listening there, and with SO_BROADCAST set so proxy-mode replies
+// reach clients that have no IP yet.
+func reusableUDPConn(ctx context.Context, iface string, addr *net.UDPAddr) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				if iface != "" {
+					sockErr = unix.BindToDevice(int(fd), iface)
+					if sockErr != nil {
+						return
+					}
+				}
+				if sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); sockErr != nil {
+					return
+				}
+				if sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); sockErr != nil {
+					return
+				}
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1)
+			})
+			if err != nil {
+				return err
+			}
+
+			return sockErr
+		},
+	}
+
+	pc, err := lc.ListenPacket(ctx, "udp4", addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind reusable udp socket on %s: %w", addr, err)
+	}
+
+	return pc, nil
+}
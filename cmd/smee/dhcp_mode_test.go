@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestParseDHCPMode(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    dhcpMode
+		wantErr bool
+	}{
+		{"reservation", modeReservation, false},
+		{"proxy", modeProxy, false},
+		{"auto", modeAuto, false},
+		{"bogus", "", true},
+		{"", "", true},
+	}
+	for _, c := range cases {
+		got, err := parseDHCPMode(c.in)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("parseDHCPMode(%q) error = %v, wantErr %v", c.in, err, c.wantErr)
+		}
+		if got != c.want {
+			t.Fatalf("parseDHCPMode(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestIsPXEClient(t *testing.T) {
+	pxe, _ := dhcpv4.New()
+	pxe.UpdateOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000"))
+
+	arch, _ := dhcpv4.New()
+	arch.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientSystemArchitectureType, []byte{0, 7}))
+
+	plain, _ := dhcpv4.New()
+
+	cases := []struct {
+		name string
+		m    *dhcpv4.DHCPv4
+		want bool
+	}{
+		{"vendor class PXEClient", pxe, true},
+		{"option 93 present", arch, true},
+		{"neither option set", plain, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isPXEClient(c.m); got != c.want {
+				t.Errorf("isPXEClient() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestHasClientIPAddr(t *testing.T) {
+	fresh, _ := dhcpv4.New()
+	fresh.ClientIPAddr = net.IPv4zero
+
+	renewing, _ := dhcpv4.New()
+	renewing.ClientIPAddr = net.IPv4(192, 168, 1, 50)
+
+	if hasClientIPAddr(fresh) {
+		t.Error("hasClientIPAddr() = true for unspecified ciaddr, want false")
+	}
+	if !hasClientIPAddr(renewing) {
+		t.Error("hasClientIPAddr() = false for set ciaddr, want true")
+	}
+}
@@ -15,6 +15,20 @@ import (
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
 
+// stringSliceFlag implements flag.Value for a flag that may be repeated on
+// the command line, e.g. -osie-profile=a=1 -osie-profile=b=2.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(v string) error {
+	*s = append(*s, v)
+
+	return nil
+}
+
 // customUsageFunc is a custom UsageFunc used for all commands.
 func customUsageFunc(c *ffcli.Command) string {
 	var b strings.Builder
@@ -105,11 +119,15 @@ func ipxeHTTPScriptFlags(c *config, fs *flag.FlagSet) {
 	fs.StringVar(&c.ipxeHTTPScript.hookURL, "osie-url", "", "[http] URL where OSIE (HookOS) images are located")
 	fs.StringVar(&c.ipxeHTTPScript.tinkServer, "tink-server", "", "[http] IP:Port for the Tink server")
 	fs.BoolVar(&c.ipxeHTTPScript.tinkServerUseTLS, "tink-server-tls", false, "[http] use TLS for Tink server")
+	fs.Var(&c.ipxeHTTPScript.osieProfileArgs, "osie-profile", "[http] a named OSIE (HookOS) profile as name=url, may be repeated; e.g. -osie-profile=default=http://2.2.2.2/amd64-uefi/ -osie-profile=arm64=http://2.2.2.2/arm64/")
+	fs.Var(&c.ipxeHTTPScript.osieOverrideArgs, "osie-profile-override", "[http] pin a specific host to an OSIE profile by mac=name, may be repeated; overrides the arch-detected profile for that host, e.g. -osie-profile-override=40:15:ff:89:cc:0e=debug. CLI-only stopgap: the original ask was a per-host override field on the backend record itself, but the vendored backend types in this tree don't expose one to set it on")
 }
 
 func dhcpFlags(c *config, fs *flag.FlagSet) {
 	fs.BoolVar(&c.dhcp.enabled, "dhcp-enabled", true, "[dhcp] enable DHCP server")
+	fs.StringVar(&c.dhcp.mode, "dhcp-mode", "reservation", "[dhcp] DHCP mode: reservation (Smee assigns leases), proxy (RFC 4578 ProxyDHCP alongside an existing site DHCP server), or auto (leases for fresh requests on -dhcp-addr, boot options only for renewals there and on -dhcp-proxy-addr)")
 	fs.StringVar(&c.dhcp.bindAddr, "dhcp-addr", "0.0.0.0:67", "[dhcp] local IP:Port to listen on for DHCP requests")
+	fs.StringVar(&c.dhcp.proxyAddr, "dhcp-proxy-addr", "0.0.0.0:4011", "[dhcp] local IP:Port for the dedicated RFC 4578 ProxyDHCP listener started in -dhcp-mode=auto")
 	fs.StringVar(&c.dhcp.bindInterface, "dhcp-iface", "", "[dhcp] interface to bind to for DHCP requests")
 	fs.StringVar(&c.dhcp.ipForPacket, "dhcp-ip-for-packet", detectPublicIPv4(""), "[dhcp] IP address to use in DHCP packets (opt 54, etc)")
 	fs.StringVar(&c.dhcp.syslogIP, "dhcp-syslog-ip", detectPublicIPv4(""), "[dhcp] Syslog server IP address to use in DHCP packets (opt 7)")
@@ -120,12 +138,17 @@ func dhcpFlags(c *config, fs *flag.FlagSet) {
 }
 
 func backendFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.backends.order, "backend-order", "kubernetes", "[backend] comma separated, priority ordered list of backends to query (file,kubernetes); the first backend with a hit for a given lookup wins")
 	fs.BoolVar(&c.backends.file.Enabled, "backend-file-enabled", false, "[backend] enable the file backend for DHCP and the HTTP iPXE script")
 	fs.StringVar(&c.backends.file.FilePath, "backend-file-path", "", "[backend] the hardware yaml file path for the file backend")
+	fs.BoolVar(&c.backends.file.ReadOnly, "backend-file-read-only", true, "[backend] no-op placeholder reserved for a future write path; the file backend is read-only today regardless of this flag")
+	fs.BoolVar(&c.backends.fileHardError, "backend-file-hard-error", true, "[backend] treat a file backend error (as opposed to an ordinary not-found miss) as fatal to the lookup instead of falling through to the next backend")
 	fs.BoolVar(&c.backends.kubernetes.Enabled, "backend-kube-enabled", true, "[backend] enable the kubernetes backend for DHCP and the HTTP iPXE script")
 	fs.StringVar(&c.backends.kubernetes.ConfigFilePath, "backend-kube-config", "", "[backend] the Kubernetes config file location, kube backend only")
 	fs.StringVar(&c.backends.kubernetes.APIURL, "backend-kube-api", "", "[backend] the Kubernetes API URL, used for in-cluster client construction, kube backend only")
 	fs.StringVar(&c.backends.kubernetes.Namespace, "backend-kube-namespace", "", "[backend] an optional Kubernetes namespace override to query hardware data from, kube backend only")
+	fs.BoolVar(&c.backends.kubernetes.ReadOnly, "backend-kube-read-only", false, "[backend] no-op placeholder reserved for a future write path; the kubernetes backend is read-only today regardless of this flag")
+	fs.BoolVar(&c.backends.kubeHardError, "backend-kube-hard-error", true, "[backend] treat a kubernetes backend error (as opposed to an ordinary not-found miss) as fatal to the lookup instead of falling through to the next backend")
 }
 
 func setFlags(c *config, fs *flag.FlagSet) {
@@ -134,8 +157,35 @@ func setFlags(c *config, fs *flag.FlagSet) {
 	tftpFlags(c, fs)
 	ipxeHTTPBinaryFlags(c, fs)
 	ipxeHTTPScriptFlags(c, fs)
+	httpTLSFlags(c, fs)
 	syslogFlags(c, fs)
 	backendFlags(c, fs)
+	registryFlags(c, fs)
+	monitorFlags(c, fs)
+}
+
+// applyTLSAddrDefaults moves -http-addr to the conventional TLS port when
+// TLS/ACME is enabled and the operator didn't set -http-addr explicitly, then
+// rejects a remaining collision between -http-addr and
+// -http-acme-challenge-addr: with ACME enabled, the iPXE HTTP server and the
+// ACME HTTP-01 challenge listener are separate listeners that cannot both
+// bind the same port.
+func applyTLSAddrDefaults(fs *flag.FlagSet, c *config) error {
+	if !c.ipxeHTTPScript.tls.enabled {
+		return nil
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if !explicit["http-addr"] {
+		c.ipxeHTTPScript.bindAddr = detectPublicIPv4(":443")
+	}
+
+	if c.ipxeHTTPScript.tls.acme.enabled && portOf(c.ipxeHTTPScript.bindAddr) == portOf(c.ipxeHTTPScript.tls.acme.challengeAddr) {
+		return fmt.Errorf("-http-addr (%s) and -http-acme-challenge-addr (%s) must not share a port: the TLS iPXE HTTP listener and the ACME HTTP-01 challenge listener bind separately and one will fail to start", c.ipxeHTTPScript.bindAddr, c.ipxeHTTPScript.tls.acme.challengeAddr)
+	}
+
+	return nil
 }
 
 func newCLI(cfg *config, fs *flag.FlagSet) *ffcli.Command {
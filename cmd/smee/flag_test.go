@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestApplyTLSAddrDefaults(t *testing.T) {
+	t.Run("tls disabled leaves http-addr alone", func(t *testing.T) {
+		c := &config{}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		c.ipxeHTTPScript.bindAddr = "1.2.3.4:80"
+		if err := applyTLSAddrDefaults(fs, c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c.ipxeHTTPScript.bindAddr != "1.2.3.4:80" {
+			t.Errorf("bindAddr = %q, want unchanged", c.ipxeHTTPScript.bindAddr)
+		}
+	})
+
+	t.Run("tls enabled defaults http-addr to 443 when not set explicitly", func(t *testing.T) {
+		c := &config{}
+		c.ipxeHTTPScript.tls.enabled = true
+		c.ipxeHTTPScript.bindAddr = "1.2.3.4:80"
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		if err := applyTLSAddrDefaults(fs, c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := c.ipxeHTTPScript.bindAddr; got == "" || got[len(got)-4:] != ":443" {
+			t.Errorf("bindAddr = %q, want a :443 address", got)
+		}
+	})
+
+	t.Run("explicit -http-addr is respected even with tls enabled", func(t *testing.T) {
+		c := &config{}
+		c.ipxeHTTPScript.tls.enabled = true
+		c.ipxeHTTPScript.bindAddr = "1.2.3.4:8443"
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("http-addr", "", "")
+		fs.Set("http-addr", "1.2.3.4:8443") //nolint:errcheck
+		if err := applyTLSAddrDefaults(fs, c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c.ipxeHTTPScript.bindAddr != "1.2.3.4:8443" {
+			t.Errorf("bindAddr = %q, want unchanged explicit value", c.ipxeHTTPScript.bindAddr)
+		}
+	})
+
+	t.Run("acme enabled and challenge addr sharing a port is rejected", func(t *testing.T) {
+		c := &config{}
+		c.ipxeHTTPScript.tls.enabled = true
+		c.ipxeHTTPScript.tls.acme.enabled = true
+		c.ipxeHTTPScript.bindAddr = "1.2.3.4:80"
+		c.ipxeHTTPScript.tls.acme.challengeAddr = ":80"
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("http-addr", "", "")
+		fs.Set("http-addr", "1.2.3.4:80") //nolint:errcheck
+		if err := applyTLSAddrDefaults(fs, c); err == nil {
+			t.Fatal("expected error when -http-addr and -http-acme-challenge-addr share a port")
+		}
+	})
+}
@@ -6,12 +6,14 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	stdhttp "net/http"
 	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
 	"path"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -53,6 +55,8 @@ type config struct {
 	// loglevel is the log level for smee.
 	logLevel string
 	backends dhcpBackends
+	registry registryConfig
+	monitor  monitorConfig
 }
 
 type syslogConfig struct {
@@ -80,11 +84,20 @@ type ipxeHTTPScript struct {
 	tinkServer       string
 	tinkServerUseTLS bool
 	trustedProxies   string
+	tls              httpTLS
+	// osieProfileArgs holds the raw -osie-profile flag values (name=url),
+	// parsed into osieProfiles before being handed to script.Handler.
+	osieProfileArgs stringSliceFlag
+	// osieOverrideArgs holds the raw -osie-profile-override flag values
+	// (mac=profile), parsed into osieOverrides.
+	osieOverrideArgs stringSliceFlag
 }
 
 type dhcpConfig struct {
 	enabled           bool
+	mode              string
 	bindAddr          string
+	proxyAddr         string
 	bindInterface     string
 	ipForPacket       string
 	syslogIP          string
@@ -102,14 +115,27 @@ type httpIpxeScript struct {
 }
 
 type dhcpBackends struct {
+	// order is a comma separated, priority ordered list of backend names
+	// (file, kubernetes) to query. The first backend with a hit wins.
+	order      string
 	file       File
 	kubernetes Kube
+	// fileHardError and kubeHardError control whether an error from the
+	// respective backend (distinct from an ordinary not-found miss) stops
+	// the composite lookup immediately instead of falling through to the
+	// next backend in order.
+	fileHardError bool
+	kubeHardError bool
 }
 
 func main() {
 	cfg := &config{}
-	cli := newCLI(cfg, flag.NewFlagSet(name, flag.ExitOnError))
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	cli := newCLI(cfg, fs)
 	_ = cli.Parse(os.Args[1:])
+	if err := applyTLSAddrDefaults(fs, cfg); err != nil {
+		panic(err)
+	}
 
 	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGHUP, syscall.SIGTERM)
 	defer done()
@@ -120,7 +146,35 @@ func main() {
 	log := defaultLogger(cfg.logLevel)
 	log.Info("starting", "version", GitRev)
 
+	if cfg.ipxeHTTPScript.tls.enabled {
+		patch, err := cfg.ipxeHTTPScript.tls.caBundlePatch()
+		if err != nil {
+			log.Error(err, "failed to build ca bundle ipxe script patch")
+			panic(fmt.Errorf("failed to build ca bundle ipxe script patch: %w", err))
+		}
+		cfg.tftp.ipxeScriptPatch += patch
+	}
+
+	readiness := newReadinessRegistry()
+
 	g, ctx := errgroup.WithContext(ctx)
+	// monitor (metrics, healthz, readyz, pprof)
+	if cfg.monitor.enabled {
+		log.Info("serving monitor endpoint", "addr", cfg.monitor.bindAddr)
+		monitorServer := &stdhttp.Server{Addr: cfg.monitor.bindAddr, Handler: monitorMux(readiness)}
+		g.Go(func() error {
+			go func() {
+				<-ctx.Done()
+				_ = monitorServer.Close()
+			}()
+			if err := monitorServer.ListenAndServe(); err != nil && !errors.Is(err, stdhttp.ErrServerClosed) {
+				return err
+			}
+
+			return nil
+		})
+	}
+
 	// syslog
 	if cfg.syslog.enabled {
 		log.Info("starting syslog server", "bind_addr", cfg.syslog.bindAddr)
@@ -153,6 +207,15 @@ func main() {
 			}
 			// start the ipxe binary tftp server
 			log.Info("starting tftp server", "bind_addr", cfg.tftp.bindAddr)
+			var tftpStarted atomic.Bool
+			readiness.Register("tftp", func() error {
+				if !tftpStarted.Load() {
+					return fmt.Errorf("tftp server not yet accepting on %s", cfg.tftp.bindAddr)
+				}
+
+				return nil
+			})
+			go watchBound(ctx, "udp", cfg.tftp.bindAddr, &tftpStarted)
 			g.Go(func() error {
 				return tftpServer.ListenAndServe(ctx)
 			})
@@ -162,6 +225,20 @@ func main() {
 		}
 	}
 
+	// Both the DHCP handler and the HTTP iPXE script handler read hardware
+	// data from the same configured backends, so build a single, shared
+	// backend reader and readiness probe rather than standing up a second,
+	// independently-syncing instance for each consumer.
+	var br handler.BackendReader
+	if cfg.dhcp.enabled || cfg.ipxeHTTPScript.enabled {
+		var err error
+		br, err = cfg.buildBackendReader(ctx, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to build backend reader: %w", err))
+		}
+		readiness.Register("backend", func() error { return backendReadiness(br) })
+	}
+
 	handlers := http.HandlerMapping{}
 	// http ipxe binaries
 	if cfg.ipxeHTTPBinary.enabled {
@@ -172,31 +249,37 @@ func main() {
 		}.Handle
 	}
 
+	// embedded pull-through OCI registry mirror
+	if cfg.registry.enabled {
+		mirror, err := newRegistryMirror(log.WithValues("service", "github.com/tinkerbell/smee").WithName("registry"), cfg.registry)
+		if err != nil {
+			panic(fmt.Errorf("failed to start registry mirror: %w", err))
+		}
+		log.Info("registry mirror enabled", "upstreams", cfg.registry.upstreams, "cache_dir", cfg.registry.cacheDir)
+		handlers["/v2/"] = mirror.Handler()
+	}
+
 	// http ipxe script
 	if cfg.ipxeHTTPScript.enabled {
-		var br handler.BackendReader
-		switch {
-		case cfg.backends.file.Enabled && cfg.backends.kubernetes.Enabled:
-			panic("only one backend can be enabled at a time")
-		case cfg.backends.file.Enabled:
-			b, err := cfg.backends.file.Backend(ctx, log)
-			if err != nil {
-				panic(fmt.Errorf("failed to run file backend: %w", err))
-			}
-			br = b
-		default: // default backend is kubernetes
-			b, err := cfg.backends.kubernetes.Backend(ctx)
-			if err != nil {
-				panic(fmt.Errorf("failed to run kubernetes backend: %w", err))
-			}
-			br = b
+		osieProfiles, err := parseOSIEProfiles(cfg.ipxeHTTPScript.osieProfileArgs)
+		if err != nil {
+			panic(err)
+		}
+		if len(osieProfiles) == 0 && cfg.ipxeHTTPScript.hookURL != "" {
+			osieProfiles[defaultOSIEProfile] = cfg.ipxeHTTPScript.hookURL
+		}
+
+		extraKernelParams := strings.Split(cfg.ipxeHTTPScript.extraKernelArgs, " ")
+		if cfg.registry.enabled {
+			extraKernelParams = append(extraKernelParams, registryArg(cfg.dhcp.ipForPacket+portOf(cfg.ipxeHTTPScript.bindAddr)))
 		}
 
 		jh := script.Handler{
 			Logger:             log,
 			Backend:            br,
-			OSIEURL:            cfg.ipxeHTTPScript.hookURL,
-			ExtraKernelParams:  strings.Split(cfg.ipxeHTTPScript.extraKernelArgs, " "),
+			OSIEURL:            osieProfiles[defaultOSIEProfile],
+			OSIEURLs:           osieProfiles,
+			ExtraKernelParams:  extraKernelParams,
 			PublicSyslogFQDN:   cfg.dhcp.syslogIP,
 			TinkServerTLS:      cfg.ipxeHTTPScript.tinkServerUseTLS,
 			TinkServerGRPCAddr: cfg.ipxeHTTPScript.tinkServer,
@@ -206,6 +289,15 @@ func main() {
 	}
 
 	if len(handlers) > 0 {
+		var httpStarted atomic.Bool
+		readiness.Register("http", func() error {
+			if !httpStarted.Load() {
+				return fmt.Errorf("iPXE http handlers not yet serving on %s", cfg.ipxeHTTPScript.bindAddr)
+			}
+
+			return nil
+		})
+
 		// start the http server for ipxe binaries and scripts
 		httpServer := &http.Config{
 			GitRev:         GitRev,
@@ -213,34 +305,111 @@ func main() {
 			Logger:         log,
 			TrustedProxies: parseTrustedProxies(cfg.ipxeHTTPScript.trustedProxies),
 		}
-		log.Info("serving http", "addr", cfg.ipxeHTTPScript.bindAddr)
-		g.Go(func() error {
-			return httpServer.ServeHTTP(ctx, cfg.ipxeHTTPScript.bindAddr, handlers)
-		})
+		if cfg.ipxeHTTPScript.tls.enabled {
+			tlsConf, acmeManager, err := cfg.ipxeHTTPScript.tls.tlsConfig(ctx)
+			if err != nil {
+				log.Error(err, "failed to configure http tls")
+				panic(fmt.Errorf("failed to configure http tls: %w", err))
+			}
+			log.Info("serving https", "addr", cfg.ipxeHTTPScript.bindAddr)
+			go watchBound(ctx, "tcp", cfg.ipxeHTTPScript.bindAddr, &httpStarted)
+			g.Go(func() error {
+				return httpServer.ServeHTTPTLS(ctx, cfg.ipxeHTTPScript.bindAddr, handlers, tlsConf)
+			})
+			// ACME requires a plain HTTP listener to answer HTTP-01 challenges,
+			// which must be reachable on port 80 regardless of where the iPXE
+			// HTTP script/binary server itself listens.
+			if acmeManager != nil {
+				addr := cfg.ipxeHTTPScript.tls.acme.challengeAddr
+				log.Info("serving acme http-01 challenge", "addr", addr)
+				g.Go(func() error {
+					l, err := challengeListener(addr, acmeManager)
+					if err != nil {
+						return fmt.Errorf("failed to start acme challenge listener: %w", err)
+					}
+					srv := &stdhttp.Server{Handler: acmeManager.HTTPHandler(nil)}
+					go func() {
+						<-ctx.Done()
+						_ = srv.Close()
+					}()
+
+					return srv.Serve(l)
+				})
+			}
+		} else {
+			log.Info("serving http", "addr", cfg.ipxeHTTPScript.bindAddr)
+			go watchBound(ctx, "tcp", cfg.ipxeHTTPScript.bindAddr, &httpStarted)
+			g.Go(func() error {
+				return httpServer.ServeHTTP(ctx, cfg.ipxeHTTPScript.bindAddr, handlers)
+			})
+		}
 	}
 
 	// dhcp server
 	if cfg.dhcp.enabled {
-		dh, err := cfg.dhcpHandler(ctx, log)
+		mode, err := parseDHCPMode(cfg.dhcp.mode)
+		if err != nil {
+			panic(err)
+		}
+		dh, err := cfg.dhcpHandler(br, log)
 		if err != nil {
 			log.Error(err, "failed to create dhcp listener")
 			panic(fmt.Errorf("failed to create dhcp listener: %w", err))
 		}
-		log.Info("starting dhcp server", "bind_addr", cfg.dhcp.bindAddr)
+		wrapped := wrapForMode(mode, log, dh)
+		log.Info("starting dhcp server", "bind_addr", cfg.dhcp.bindAddr, "mode", mode)
+
+		bindAddr, err := netip.ParseAddrPort(cfg.dhcp.bindAddr)
+		if err != nil {
+			panic(fmt.Errorf("invalid bind address for DHCP server: %w", err))
+		}
+		var conn net.PacketConn
+		if mode == modeProxy || mode == modeAuto {
+			// In proxy/auto mode Smee shares :67 (or :4011) with the
+			// site DHCP server, so the socket must be bound with
+			// SO_REUSEPORT rather than exclusively.
+			conn, err = reusableUDPConn(ctx, cfg.dhcp.bindInterface, net.UDPAddrFromAddrPort(bindAddr))
+		} else {
+			conn, err = server4.NewIPv4UDPConn(cfg.dhcp.bindInterface, net.UDPAddrFromAddrPort(bindAddr))
+		}
+		if err != nil {
+			panic(fmt.Errorf("failed to bind dhcp socket: %w", err))
+		}
+		// the socket is bound at this point, so the dhcp readiness probe can
+		// report ready even before the first packet is served.
+		readiness.Register("dhcp", func() error { return nil })
+
 		g.Go(func() error {
-			bindAddr, err := netip.ParseAddrPort(cfg.dhcp.bindAddr)
+			defer conn.Close()
+			ds := &dhcp.Server{Logger: log, Conn: conn, Handlers: []dhcp.Handler{wrapped}}
+
+			return ds.Serve(ctx)
+		})
+
+		// In auto mode, also run a dedicated RFC 4578 ProxyDHCP listener on
+		// its own port (:4011 by default). Clients that already obtained a
+		// lease from the site DHCP server unicast their second-phase PXE
+		// boot request here; this listener is always proxy-only, unlike
+		// the :67 listener above which still assigns leases for anything
+		// that looks like a fresh DISCOVER.
+		if mode == modeAuto {
+			proxyAddr, err := netip.ParseAddrPort(cfg.dhcp.proxyAddr)
 			if err != nil {
-				panic(fmt.Errorf("invalid tftp address for DHCP server: %w", err))
+				panic(fmt.Errorf("invalid dhcp proxy address: %w", err))
 			}
-			conn, err := server4.NewIPv4UDPConn(cfg.dhcp.bindInterface, net.UDPAddrFromAddrPort(bindAddr))
+			proxyConn, err := server4.NewIPv4UDPConn(cfg.dhcp.bindInterface, net.UDPAddrFromAddrPort(proxyAddr))
 			if err != nil {
-				panic(err)
+				panic(fmt.Errorf("failed to bind dhcp proxy socket: %w", err))
 			}
-			defer conn.Close()
-			ds := &dhcp.Server{Logger: log, Conn: conn, Handlers: []dhcp.Handler{dh}}
+			log.Info("starting dhcp proxy listener", "bind_addr", cfg.dhcp.proxyAddr)
+			readiness.Register("dhcp-proxy", func() error { return nil })
+			g.Go(func() error {
+				defer proxyConn.Close()
+				ds := &dhcp.Server{Logger: log, Conn: proxyConn, Handlers: []dhcp.Handler{&proxyDHCPHandler{next: dh, log: log}}}
 
-			return ds.Serve(ctx)
-		})
+				return ds.Serve(ctx)
+			})
+		}
 	}
 
 	if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
@@ -250,7 +419,7 @@ func main() {
 	log.Info("smee is shutting down")
 }
 
-func (c *config) dhcpHandler(ctx context.Context, log logr.Logger) (*reservation.Handler, error) {
+func (c *config) dhcpHandler(br handler.BackendReader, log logr.Logger) (*reservation.Handler, error) {
 	// 1. create the handler
 	// 2. create the backend
 	// 3. add the backend to the handler
@@ -270,6 +439,21 @@ func (c *config) dhcpHandler(ctx context.Context, log logr.Logger) (*reservation
 	if err != nil || httpScriptURL == nil {
 		return nil, fmt.Errorf("invalid http ipxe script url: %w", err)
 	}
+	if c.ipxeHTTPScript.tls.enabled {
+		httpBinaryURL.Scheme = "https"
+		httpScriptURL.Scheme = "https"
+	}
+	osieProfiles, err := parseOSIEProfiles(c.ipxeHTTPScript.osieProfileArgs)
+	if err != nil {
+		return nil, err
+	}
+	if len(osieProfiles) == 0 && c.ipxeHTTPScript.hookURL != "" {
+		osieProfiles[defaultOSIEProfile] = c.ipxeHTTPScript.hookURL
+	}
+	osieOverrides, err := parseOSIEOverrides(c.ipxeHTTPScript.osieOverrideArgs)
+	if err != nil {
+		return nil, err
+	}
 	ipxeScript := func(d *dhcpv4.DHCPv4) *url.URL {
 		return httpScriptURL
 	}
@@ -281,14 +465,29 @@ func (c *config) dhcpHandler(ctx context.Context, log logr.Logger) (*reservation
 			return &u
 		}
 	}
+	if len(osieProfiles) > 1 || len(osieOverrides) > 0 {
+		next := ipxeScript
+		ipxeScript = func(d *dhcpv4.DHCPv4) *url.URL {
+			u := *next(d)
+			profile := selectOSIEProfile(osieProfiles, osieOverrides[d.ClientHWAddr.String()], d)
+			log.V(1).Info("selected osie profile", "profile", profile, "mac", d.ClientHWAddr.String())
+			q := u.Query()
+			q.Set("osie_profile", profile)
+			if arch, ok := requestArch(d); ok {
+				q.Set("ipxe_binary", archBinaryFilename(arch))
+			}
+			u.RawQuery = q.Encode()
+
+			return &u
+		}
+	}
 	syslogIP, err := netip.ParseAddr(c.dhcp.syslogIP)
 	if err != nil {
 		return nil, fmt.Errorf("invalid syslog address: %w", err)
 	}
 	dh := &reservation.Handler{
-		Backend: nil,
-		IPAddr:  pktIP,
-		Log:     log,
+		IPAddr: pktIP,
+		Log:    log,
 		Netboot: reservation.Netboot{
 			IPXEBinServerTFTP: tftpIP,
 			IPXEBinServerHTTP: httpBinaryURL,
@@ -297,22 +496,7 @@ func (c *config) dhcpHandler(ctx context.Context, log logr.Logger) (*reservation
 		},
 		OTELEnabled: true,
 		SyslogAddr:  syslogIP,
-	}
-	switch {
-	case c.backends.file.Enabled && c.backends.kubernetes.Enabled:
-		panic("only one backend can be enabled at a time")
-	case c.backends.file.Enabled:
-		b, err := c.backends.file.Backend(ctx, log)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create file backend: %w", err)
-		}
-		dh.Backend = b
-	default: // default backend is kubernetes
-		b, err := c.backends.kubernetes.Backend(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create kubernetes backend: %w", err)
-		}
-		dh.Backend = b
+		Backend:     br,
 	}
 
 	return dh, nil
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// defaultOSIEProfile is the profile name used when a request doesn't match
+// any arch-specific profile and no per-host override is set.
+const defaultOSIEProfile = "default"
+
+// osieProfiles maps a profile name (default, arm64, debug, ...) to the base
+// URL serving that profile's OSIE (HookOS) kernel/initramfs.
+type osieProfiles map[string]string
+
+// parseOSIEProfiles parses one or more -osie-profile flag values of the form
+// "name=url", e.g. "default=http://2.2.2.2/amd64-uefi/,arm64=http://2.2.2.2/arm64/".
+func parseOSIEProfiles(values []string) (osieProfiles, error) {
+	profiles := osieProfiles{}
+	for _, v := range values {
+		for _, entry := range strings.Split(v, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			name, url, ok := strings.Cut(entry, "=")
+			if !ok || name == "" || url == "" {
+				return nil, fmt.Errorf("invalid -osie-profile entry %q, expected name=url", entry)
+			}
+			profiles[name] = url
+		}
+	}
+
+	return profiles, nil
+}
+
+// osieOverrides maps a normalized MAC address to the OSIE profile name that
+// host should always get, regardless of what DHCP option 93 reports. This
+// is a stopgap until per-host profile selection can live on the hardware
+// record itself in the backend: the backend interface in this tree
+// (handler.BackendReader / data.Netboot, from the vendored
+// github.com/tinkerbell/dhcp module) has no such field, so -osie-profile-
+// override is the only place Smee can source an override from today.
+type osieOverrides map[string]string
+
+// parseOSIEOverrides parses one or more -osie-profile-override flag values
+// of the form "mac=profile", e.g.
+// "40:15:ff:89:cc:0e=debug,98:03:9b:2a:11:04=arm64".
+func parseOSIEOverrides(values []string) (osieOverrides, error) {
+	overrides := osieOverrides{}
+	for _, v := range values {
+		for _, entry := range strings.Split(v, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			rawMAC, profile, ok := strings.Cut(entry, "=")
+			if !ok || rawMAC == "" || profile == "" {
+				return nil, fmt.Errorf("invalid -osie-profile-override entry %q, expected mac=profile", entry)
+			}
+			mac, err := net.ParseMAC(rawMAC)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -osie-profile-override entry %q: %w", entry, err)
+			}
+			overrides[mac.String()] = profile
+		}
+	}
+
+	return overrides, nil
+}
+
+// archProfile maps a DHCP option 93 client system architecture to the OSIE
+// profile name that should serve it, absent any per-host override. Unknown
+// architectures fall through to the default profile.
+func archProfile(arch iana.Arch) string {
+	switch arch {
+	case iana.EFI_ARM64:
+		return "arm64"
+	case iana.EFI_ITANIUM, iana.EFI_X86_64, iana.EFI_XSCALE, iana.EFI_BC:
+		return defaultOSIEProfile
+	case iana.INTEL_X86PC:
+		return defaultOSIEProfile
+	default:
+		return defaultOSIEProfile
+	}
+}
+
+// selectOSIEProfile picks the profile to serve a given request: an explicit
+// per-host override wins, then the arch detected from DHCP option 93, then
+// the default profile. It always falls back to a profile present in
+// profiles, preferring "default" and finally the alphabetically first
+// remaining configured profile name, so the fallthrough is deterministic
+// across requests and restarts rather than depending on Go's randomized map
+// iteration order.
+//
+// The request that introduced this also asked for the hardware record's
+// declared arch/firmware as a signal; the backend types available in this
+// tree (see the osieOverrides doc comment) don't expose one, so DHCP option
+// 93 -- the signal the netboot protocol itself already carries -- is the
+// only arch source consulted here.
+func selectOSIEProfile(profiles osieProfiles, override string, m *dhcpv4.DHCPv4) string {
+	if override != "" {
+		if _, ok := profiles[override]; ok {
+			return override
+		}
+	}
+	if m != nil {
+		if opt := m.GetOneOption(dhcpv4.OptionClientSystemArchitectureType); opt != nil && len(opt) >= 2 {
+			arch := iana.Arch(uint16(opt[0])<<8 | uint16(opt[1]))
+			if name := archProfile(arch); profiles[name] != "" {
+				return name
+			}
+		}
+	}
+	if _, ok := profiles[defaultOSIEProfile]; ok {
+		return defaultOSIEProfile
+	}
+	if len(profiles) > 0 {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return names[0]
+	}
+
+	return defaultOSIEProfile
+}
+
+// requestArch reads the client system architecture out of DHCP option 93,
+// if present.
+func requestArch(m *dhcpv4.DHCPv4) (iana.Arch, bool) {
+	if m == nil {
+		return 0, false
+	}
+	opt := m.GetOneOption(dhcpv4.OptionClientSystemArchitectureType)
+	if opt == nil || len(opt) < 2 {
+		return 0, false
+	}
+
+	return iana.Arch(uint16(opt[0])<<8 | uint16(opt[1])), true
+}
+
+// archBinaryFilename returns the iPXE binary filename the client of the
+// given architecture should chainload, mirroring the arch routing
+// ipxedust/reservation.Handler already performs for the DHCP
+// next-server/bootfile-name fields. The DHCP handler's IPXEScriptURL
+// closure passes the result to script.Handler as the "ipxe_binary" query
+// parameter, which the rendered iPXE script names in a comment line; the
+// script does not chainload that binary itself.
+func archBinaryFilename(arch iana.Arch) string {
+	switch arch {
+	case iana.EFI_ARM64:
+		return "snp-arm64.efi"
+	case iana.EFI_X86_64, iana.EFI_BC:
+		return "snp.efi"
+	case iana.EFI_ITANIUM, iana.EFI_XSCALE:
+		return "ipxe.efi"
+	case iana.INTEL_X86PC:
+		return "undionly.kpxe"
+	default:
+		return "undionly.kpxe"
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestParseOSIEProfiles(t *testing.T) {
+	got, err := parseOSIEProfiles([]string{"default=http://2.2.2.2/amd64-uefi/,arm64=http://2.2.2.2/arm64/", "debug=http://2.2.2.2/debug/"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := osieProfiles{
+		"default": "http://2.2.2.2/amd64-uefi/",
+		"arm64":   "http://2.2.2.2/arm64/",
+		"debug":   "http://2.2.2.2/debug/",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseOSIEProfiles() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("parseOSIEProfiles()[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+
+	if _, err := parseOSIEProfiles([]string{"no-equals-sign"}); err == nil {
+		t.Error("expected error for malformed -osie-profile entry")
+	}
+}
+
+func TestParseOSIEOverrides(t *testing.T) {
+	got, err := parseOSIEOverrides([]string{"40:15:ff:89:cc:0e=debug"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["40:15:ff:89:cc:0e"] != "debug" {
+		t.Errorf("parseOSIEOverrides() = %v, want mac mapped to debug", got)
+	}
+
+	if _, err := parseOSIEOverrides([]string{"not-a-mac=debug"}); err == nil {
+		t.Error("expected error for invalid mac in -osie-profile-override entry")
+	}
+	if _, err := parseOSIEOverrides([]string{"40:15:ff:89:cc:0e"}); err == nil {
+		t.Error("expected error for missing '=' in -osie-profile-override entry")
+	}
+}
+
+func TestSelectOSIEProfile(t *testing.T) {
+	profiles := osieProfiles{"default": "http://h/default/", "arm64": "http://h/arm64/", "debug": "http://h/debug/"}
+
+	arm64Req, _ := dhcpv4.New()
+	arm64Req.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientSystemArchitectureType, []byte{byte(iana.EFI_ARM64 >> 8), byte(iana.EFI_ARM64)}))
+
+	x86Req, _ := dhcpv4.New()
+	x86Req.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientSystemArchitectureType, []byte{byte(iana.INTEL_X86PC >> 8), byte(iana.INTEL_X86PC)}))
+
+	cases := []struct {
+		name     string
+		override string
+		m        *dhcpv4.DHCPv4
+		want     string
+	}{
+		{"override wins", "debug", arm64Req, "debug"},
+		{"unknown override falls through to arch", "nope", arm64Req, "arm64"},
+		{"arch resolves to default profile", "", x86Req, "default"},
+		{"no dhcp message falls back to default", "", nil, "default"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := selectOSIEProfile(profiles, c.override, c.m); got != c.want {
+				t.Errorf("selectOSIEProfile() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSelectOSIEProfileFallbackIsDeterministic(t *testing.T) {
+	profiles := osieProfiles{"zulu": "http://h/zulu/", "alpha": "http://h/alpha/", "mike": "http://h/mike/"}
+	for i := 0; i < 20; i++ {
+		if got := selectOSIEProfile(profiles, "", nil); got != "alpha" {
+			t.Fatalf("selectOSIEProfile() = %q, want %q (run %d)", got, "alpha", i)
+		}
+	}
+}
+
+func TestArchBinaryFilename(t *testing.T) {
+	cases := []struct {
+		arch iana.Arch
+		want string
+	}{
+		{iana.EFI_ARM64, "snp-arm64.efi"},
+		{iana.EFI_X86_64, "snp.efi"},
+		{iana.INTEL_X86PC, "undionly.kpxe"},
+	}
+	for _, c := range cases {
+		if got := archBinaryFilename(c.arch); got != c.want {
+			t.Errorf("archBinaryFilename(%v) = %q, want %q", c.arch, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	stdhttp "net/http"
+	"net/http/pprof"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// readinessProbe reports nil once the subsystem it represents is actually
+// serving traffic, and a descriptive error otherwise.
+type readinessProbe func() error
+
+// readinessRegistry tracks the probes for every subsystem smee has enabled,
+// so /readyz can report 503 until all of them are up. This is what lets a
+// Kubernetes rolling upgrade of Smee wait for the new pod to actually be
+// serving netboot before routing traffic to it.
+type readinessRegistry struct {
+	mu     sync.Mutex
+	probes map[string]readinessProbe
+}
+
+func newReadinessRegistry() *readinessRegistry {
+	return &readinessRegistry{probes: map[string]readinessProbe{}}
+}
+
+// Register adds a named probe. Registering two probes under the same name
+// replaces the first; callers should use one name per subsystem (dhcp,
+// tftp, http, backend).
+func (r *readinessRegistry) Register(name string, p readinessProbe) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.probes[name] = p
+}
+
+// notReady runs every registered probe and returns the subset that are
+// failing, keyed by subsystem name.
+func (r *readinessRegistry) notReady() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failing := map[string]string{}
+	for name, probe := range r.probes {
+		if err := probe(); err != nil {
+			failing[name] = err.Error()
+		}
+	}
+
+	return failing
+}
+
+func (r *readinessRegistry) readyzHandler() stdhttp.HandlerFunc {
+	return func(w stdhttp.ResponseWriter, _ *stdhttp.Request) {
+		failing := r.notReady()
+		w.Header().Set("Content-Type", "application/json")
+		if len(failing) > 0 {
+			w.WriteHeader(stdhttp.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]any{"ready": false, "not_ready": failing})
+
+			return
+		}
+		w.WriteHeader(stdhttp.StatusOK)
+		_ = json.NewEncoder(w).Encode(map[string]any{"ready": true})
+	}
+}
+
+func healthzHandler() stdhttp.HandlerFunc {
+	return func(w stdhttp.ResponseWriter, _ *stdhttp.Request) {
+		w.WriteHeader(stdhttp.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
+// watchBound polls until a listener can no longer be opened on network/addr
+// because something else already holds it, then sets bound to true. This is
+// used to turn a readiness probe's "started" flag into an honest signal that
+// the real server (tftp, http) has actually finished its bind, rather than
+// merely that its goroutine has been scheduled. It gives up once ctx is
+// done, leaving bound false if the real server never came up.
+func watchBound(ctx context.Context, network, addr string, bound *atomic.Bool) {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var err error
+		switch network {
+		case "udp":
+			var l net.PacketConn
+			l, err = net.ListenPacket(network, addr)
+			if l != nil {
+				l.Close()
+			}
+		default:
+			var l net.Listener
+			l, err = net.Listen(network, addr)
+			if l != nil {
+				l.Close()
+			}
+		}
+		if err != nil && errors.Is(err, syscall.EADDRINUSE) {
+			bound.Store(true)
+
+			return
+		}
+	}
+}
+
+type monitorConfig struct {
+	enabled  bool
+	bindAddr string
+}
+
+func monitorFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.monitor.enabled, "monitor-enabled", true, "[monitor] enable the operational HTTP endpoint (metrics, healthz, readyz, pprof)")
+	fs.StringVar(&c.monitor.bindAddr, "monitor-addr", ":2112", "[monitor] local IP:Port to listen on for /metrics, /healthz, /readyz, and /debug/pprof/*")
+}
+
+// monitorMux builds the handler for the operational HTTP endpoint. pprof
+// handlers are mounted explicitly, rather than relying on net/http/pprof's
+// DefaultServeMux side effect, so they only appear on this dedicated
+// listener and not on the iPXE HTTP server.
+func monitorMux(readiness *readinessRegistry) *stdhttp.ServeMux {
+	mux := stdhttp.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", healthzHandler())
+	mux.Handle("/readyz", readiness.readyzHandler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
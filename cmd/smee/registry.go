@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	stdhttp "net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	registryRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "smee",
+		Subsystem: "registry",
+		Name:      "requests_total",
+		Help:      "Total number of requests served by the embedded OCI registry mirror, partitioned by result (hit, miss).",
+	}, []string{"result"})
+
+	registryCacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "smee",
+		Subsystem: "registry",
+		Name:      "cache_bytes",
+		Help:      "Total size, in bytes, of blobs currently held in the registry mirror's on-disk cache.",
+	})
+)
+
+// registryConfig controls the optional embedded pull-through OCI registry
+// mirror, used so air-gapped or high-churn sites don't need to keep HookOS
+// and workflow action images in sync with an upstream registry by hand.
+type registryConfig struct {
+	enabled      bool
+	upstreams    string
+	cacheDir     string
+	cacheBytes   int64
+	allowedRepos string
+}
+
+func registryFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.registry.enabled, "registry-enabled", false, "[registry] enable the embedded pull-through OCI registry mirror, served at /v2/ on the iPXE HTTP listener")
+	fs.StringVar(&c.registry.upstreams, "registry-upstream", "ghcr.io", "[registry] comma separated list of upstream registries to pull-through and cache, tried in order")
+	fs.StringVar(&c.registry.cacheDir, "registry-cache-dir", "/var/lib/smee/registry", "[registry] directory to cache pulled-through blobs and manifests in")
+	fs.Int64Var(&c.registry.cacheBytes, "registry-cache-size", 10<<30, "[registry] maximum size, in bytes, of the on-disk registry mirror cache")
+	fs.StringVar(&c.registry.allowedRepos, "registry-allowed-repos", "", "[registry] comma separated list of repo path prefixes the mirror is allowed to pull through (e.g. tinkerbell/hook,tinkerbell/actions); empty allows any repo, which is an open unauthenticated proxy to the configured upstreams for anyone who can reach the iPXE HTTP listener")
+}
+
+// registryMirror is a minimal OCI Distribution v2 pull-through cache: on a
+// miss it fetches the manifest/blob from the first upstream that has it,
+// writes it to disk, and serves all subsequent requests for that digest
+// straight from the cache.
+type registryMirror struct {
+	log          logr.Logger
+	upstreams    []string
+	cacheDir     string
+	cacheBytes   int64
+	allowedRepos []string
+	client       *stdhttp.Client
+
+	// sf dedupes concurrent pull-throughs of the same ref so two requests
+	// racing on an uncached image don't both write the same cache file at
+	// once.
+	sf singleflight.Group
+}
+
+func newRegistryMirror(log logr.Logger, cfg registryConfig) (*registryMirror, error) {
+	if err := os.MkdirAll(cfg.cacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create registry cache dir %s: %w", cfg.cacheDir, err)
+	}
+
+	return &registryMirror{
+		log:          log,
+		upstreams:    splitAndTrim(cfg.upstreams),
+		cacheDir:     cfg.cacheDir,
+		cacheBytes:   cfg.cacheBytes,
+		allowedRepos: splitAndTrim(cfg.allowedRepos),
+		client:       stdhttp.DefaultClient,
+	}, nil
+}
+
+// allowed reports whether repo may be pulled through the mirror. An empty
+// allowlist permits any repo.
+func (m *registryMirror) allowed(repo string) bool {
+	if len(m.allowedRepos) == 0 {
+		return true
+	}
+	for _, prefix := range m.allowedRepos {
+		if repo == prefix || strings.HasPrefix(repo, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handler returns the http.HandlerFunc to mount at "/v2/".
+func (m *registryMirror) Handler() stdhttp.HandlerFunc {
+	return func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v2/")
+		if path == "" || path == "/" {
+			// API version check, per the OCI distribution spec.
+			w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+			w.WriteHeader(stdhttp.StatusOK)
+
+			return
+		}
+
+		ref, ok := parseRegistryPath(path)
+		if !ok {
+			stdhttp.Error(w, "not found", stdhttp.StatusNotFound)
+
+			return
+		}
+		if !m.allowed(ref.repo) {
+			m.log.V(1).Info("registry mirror rejected disallowed repo", "repo", ref.repo)
+			stdhttp.Error(w, "repo not allowed", stdhttp.StatusForbidden)
+
+			return
+		}
+
+		cacheKey := m.cacheKey(ref)
+		if f, err := os.Open(cacheKey); err == nil {
+			defer f.Close()
+			registryRequests.WithLabelValues("hit").Inc()
+			io.Copy(w, f) //nolint:errcheck
+
+			return
+		}
+
+		registryRequests.WithLabelValues("miss").Inc()
+		if err := m.pullThrough(r.Context(), ref, w); err != nil {
+			m.log.Error(err, "registry pull-through failed", "ref", ref)
+			stdhttp.Error(w, "bad gateway", stdhttp.StatusBadGateway)
+		}
+	}
+}
+
+// registryRef identifies a manifest or blob request against the mirror.
+type registryRef struct {
+	repo string
+	kind string // "manifests" or "blobs"
+	id   string // tag, digest, or reference
+}
+
+func parseRegistryPath(path string) (registryRef, bool) {
+	for _, kind := range []string{"manifests", "blobs"} {
+		marker := "/" + kind + "/"
+		if i := strings.LastIndex(path, marker); i >= 0 {
+			return registryRef{repo: path[:i], kind: kind, id: path[i+len(marker):]}, true
+		}
+	}
+
+	return registryRef{}, false
+}
+
+func (m *registryMirror) cacheKey(ref registryRef) string {
+	sum := sha256.Sum256([]byte(ref.repo + "/" + ref.kind + "/" + ref.id))
+
+	return filepath.Join(m.cacheDir, hex.EncodeToString(sum[:]))
+}
+
+// pullThrough fetches ref from the first upstream that has it into the
+// on-disk cache, then streams the now-cached file to w. Concurrent requests
+// for the same ref are deduped via singleflight, keyed on the cache path, so
+// only one of them actually talks to the upstream and writes the cache
+// file; the rest wait for it to land and then read it back like a cache
+// hit.
+func (m *registryMirror) pullThrough(ctx context.Context, ref registryRef, w io.Writer) error {
+	cacheKey := m.cacheKey(ref)
+	_, err, _ := m.sf.Do(cacheKey, func() (interface{}, error) {
+		return nil, m.fetch(ctx, ref, cacheKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(cacheKey)
+	if err != nil {
+		return fmt.Errorf("opening freshly cached %s: %w", cacheKey, err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+
+	return err
+}
+
+// fetch fetches ref from the first upstream that has it and writes it to
+// the on-disk cache at cacheKey. Only called with m.sf held for cacheKey,
+// so it is never racing another writer for the same file.
+func (m *registryMirror) fetch(ctx context.Context, ref registryRef, cacheKey string) error {
+	var lastErr error
+	for _, upstream := range m.upstreams {
+		url := fmt.Sprintf("https://%s/v2/%s/%s/%s", upstream, ref.repo, ref.kind, ref.id)
+		req, err := stdhttp.NewRequestWithContext(ctx, stdhttp.MethodGet, url, nil)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+		resp, err := m.client.Do(req)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+		if resp.StatusCode != stdhttp.StatusOK {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("upstream %s returned %s", upstream, resp.Status)
+
+			continue
+		}
+
+		tmp := cacheKey + ".tmp"
+		f, err := os.Create(tmp)
+		if err != nil {
+			resp.Body.Close()
+
+			return err
+		}
+		_, err = io.Copy(f, resp.Body)
+		resp.Body.Close()
+		f.Close()
+		if err != nil {
+			os.Remove(tmp) //nolint:errcheck
+
+			return err
+		}
+
+		if err := os.Rename(tmp, cacheKey); err != nil {
+			return err
+		}
+		m.recordCacheSize()
+
+		return nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no upstreams configured for registry mirror")
+	}
+
+	return lastErr
+}
+
+// recordCacheSize reports the current on-disk cache size and, once it
+// exceeds cacheBytes, evicts the least recently modified entries until it's
+// back under budget.
+func (m *registryMirror) recordCacheSize() {
+	entries, err := os.ReadDir(m.cacheDir)
+	if err != nil {
+		return
+	}
+
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime int64
+	}
+	var files []cacheFile
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		total += info.Size()
+		files = append(files, cacheFile{path: filepath.Join(m.cacheDir, e.Name()), size: info.Size(), modTime: info.ModTime().UnixNano()})
+	}
+	registryCacheSize.Set(float64(total))
+
+	if m.cacheBytes <= 0 || total <= m.cacheBytes {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime < files[j].modTime })
+	for _, f := range files {
+		if total <= m.cacheBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			continue
+		}
+		total -= f.size
+	}
+	registryCacheSize.Set(float64(total))
+}
+
+// portOf returns ":port" from a "host:port" bind address, or "" if addr has
+// no port. Used to point the IMAGE_REGISTRY= kernel arg at the configured
+// public IP rather than whatever the listener itself is bound to (e.g.
+// 0.0.0.0).
+func portOf(addr string) string {
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return ""
+	}
+
+	return addr[i:]
+}
+
+// registryArg renders the IMAGE_REGISTRY= kernel cmdline argument pointing
+// HookOS and Tink workers at the local mirror, so they pull workflow action
+// images from it instead of the public upstream. publicAddr is the
+// client-reachable IP:Port of the iPXE HTTP listener that the mirror is
+// mounted on.
+func registryArg(publicAddr string) string {
+	return "IMAGE_REGISTRY=" + publicAddr
+}
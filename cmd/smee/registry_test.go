@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestParseRegistryPath(t *testing.T) {
+	cases := []struct {
+		path string
+		want registryRef
+		ok   bool
+	}{
+		{"tinkerbell/hook/manifests/latest", registryRef{repo: "tinkerbell/hook", kind: "manifests", id: "latest"}, true},
+		{"tinkerbell/hook/blobs/sha256:abcd", registryRef{repo: "tinkerbell/hook", kind: "blobs", id: "sha256:abcd"}, true},
+		{"not-a-valid-path", registryRef{}, false},
+	}
+	for _, c := range cases {
+		got, ok := parseRegistryPath(c.path)
+		if ok != c.ok || got != c.want {
+			t.Errorf("parseRegistryPath(%q) = %+v, %v, want %+v, %v", c.path, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestRegistryMirrorAllowed(t *testing.T) {
+	m := &registryMirror{allowedRepos: []string{"tinkerbell/hook", "tinkerbell/actions"}}
+
+	cases := []struct {
+		repo string
+		want bool
+	}{
+		{"tinkerbell/hook", true},
+		{"tinkerbell/hook/worker", true},
+		{"tinkerbell/actions", true},
+		{"tinkerbell/hookbad", false},
+		{"someoneelse/image", false},
+	}
+	for _, c := range cases {
+		if got := m.allowed(c.repo); got != c.want {
+			t.Errorf("allowed(%q) = %v, want %v", c.repo, got, c.want)
+		}
+	}
+
+	open := &registryMirror{}
+	if !open.allowed("anything/at/all") {
+		t.Error("allowed() with an empty allowlist should permit any repo")
+	}
+}
+
+func TestCacheKeyIsStablePerRef(t *testing.T) {
+	m := &registryMirror{cacheDir: "/var/lib/smee/registry"}
+	ref := registryRef{repo: "tinkerbell/hook", kind: "manifests", id: "latest"}
+
+	a := m.cacheKey(ref)
+	b := m.cacheKey(ref)
+	if a != b {
+		t.Fatalf("cacheKey not stable across calls: %q != %q", a, b)
+	}
+
+	other := m.cacheKey(registryRef{repo: "tinkerbell/hook", kind: "manifests", id: "1.0"})
+	if a == other {
+		t.Fatalf("cacheKey collided for different refs: %q", a)
+	}
+}
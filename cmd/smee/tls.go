@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// httpTLS holds the TLS/ACME configuration for the HTTP iPXE server.
+type httpTLS struct {
+	enabled bool
+
+	// static cert/key pair, used when acme is not enabled.
+	certFile string
+	keyFile  string
+
+	acme acmeConfig
+}
+
+type acmeConfig struct {
+	enabled         bool
+	email           string
+	hosts           string
+	directoryURL    string
+	challengeAddr   string
+	cacheDir        string
+	cacheKubeSecret string
+}
+
+func httpTLSFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.ipxeHTTPScript.tls.enabled, "http-tls-enabled", false, "[http] enable TLS for the iPXE HTTP binary and script server")
+	fs.StringVar(&c.ipxeHTTPScript.tls.certFile, "http-tls-cert", "", "[http] path to a static TLS certificate, used when -http-acme-enabled is false")
+	fs.StringVar(&c.ipxeHTTPScript.tls.keyFile, "http-tls-key", "", "[http] path to a static TLS private key, used when -http-acme-enabled is false")
+	fs.BoolVar(&c.ipxeHTTPScript.tls.acme.enabled, "http-acme-enabled", false, "[http] enable on-demand ACME certificate management (Let's Encrypt) for the iPXE HTTP server")
+	fs.StringVar(&c.ipxeHTTPScript.tls.acme.email, "http-acme-email", "", "[http] contact email registered with the ACME CA")
+	fs.StringVar(&c.ipxeHTTPScript.tls.acme.hosts, "http-acme-hosts", "", "[http] comma separated list of hostnames the ACME manager is allowed to issue certificates for")
+	fs.StringVar(&c.ipxeHTTPScript.tls.acme.directoryURL, "http-acme-directory-url", acme.LetsEncryptURL, "[http] ACME CA directory URL")
+	fs.StringVar(&c.ipxeHTTPScript.tls.acme.challengeAddr, "http-acme-challenge-addr", ":80", "[http] local IP:Port to listen on for ACME HTTP-01 challenges")
+	fs.StringVar(&c.ipxeHTTPScript.tls.acme.cacheDir, "http-acme-cache-dir", "", "[http] directory to cache ACME certificates in, mutually exclusive with -http-acme-cache-kube-secret")
+	fs.StringVar(&c.ipxeHTTPScript.tls.acme.cacheKubeSecret, "http-acme-cache-kube-secret", "", "[http] namespace/name of a Kubernetes Secret to cache ACME certificates in, mutually exclusive with -http-acme-cache-dir")
+}
+
+// tlsConfig builds a *tls.Config for the iPXE HTTP server, either from a
+// static cert/key pair or from an autocert.Manager when ACME is enabled.
+// When ACME is enabled it also returns the autocert.Manager so the caller
+// can start its HTTP-01 challenge listener on the configured port.
+func (t httpTLS) tlsConfig(ctx context.Context) (*tls.Config, *autocert.Manager, error) {
+	if !t.acme.enabled {
+		cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load static TLS cert/key: %w", err)
+		}
+
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil, nil
+	}
+
+	cache, err := t.acme.cache(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build ACME cache: %w", err)
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(splitAndTrim(t.acme.hosts)...),
+		Cache:      cache,
+		Email:      t.acme.email,
+		Client:     &acme.Client{DirectoryURL: t.acme.directoryURL},
+	}
+
+	return m.TLSConfig(), m, nil
+}
+
+// caBundlePatch returns an iPXE script fragment that embeds the server's
+// root CA bundle so chainloaded iPXE binaries trust it when fetching
+// further binaries/scripts over HTTPS. When ACME is enabled this is a
+// no-op: Let's Encrypt (and most ACME CAs) chain to roots iPXE's HTTPS
+// stack already trusts.
+func (t httpTLS) caBundlePatch() (string, error) {
+	if t.acme.enabled || t.certFile == "" {
+		return "", nil
+	}
+	bundle, err := os.ReadFile(t.certFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s for ipxe-script-patch: %w", t.certFile, err)
+	}
+
+	return fmt.Sprintf("\nset ca_cert_bundle:string %s\n", strings.TrimSpace(string(bundle))), nil
+}
+
+// cache returns the autocert.Cache to use, either a disk cache or a
+// Kubernetes Secret backed cache, depending on which was configured.
+func (a acmeConfig) cache(ctx context.Context) (autocert.Cache, error) {
+	if a.cacheDir != "" && a.cacheKubeSecret != "" {
+		return nil, fmt.Errorf("-http-acme-cache-dir and -http-acme-cache-kube-secret are mutually exclusive, set only one")
+	}
+
+	switch {
+	case a.cacheKubeSecret != "":
+		ns, name, ok := strings.Cut(a.cacheKubeSecret, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid -http-acme-cache-kube-secret %q, expected namespace/name", a.cacheKubeSecret)
+		}
+		clientset, err := kubeClientset()
+		if err != nil {
+			return nil, err
+		}
+
+		return &kubeSecretCache{clientset: clientset, namespace: ns, name: name}, nil
+	case a.cacheDir != "":
+		return autocert.DirCache(a.cacheDir), nil
+	default:
+		return nil, fmt.Errorf("one of -http-acme-cache-dir or -http-acme-cache-kube-secret must be set when -http-acme-enabled is true")
+	}
+}
+
+// kubeSecretCache implements autocert.Cache by storing each cache entry as a
+// key in a single Kubernetes Secret. This lets the ACME cert/key survive pod
+// restarts without requiring a persistent volume.
+type kubeSecretCache struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+}
+
+func (k *kubeSecretCache) Get(ctx context.Context, key string) ([]byte, error) {
+	secret, err := k.clientset.CoreV1().Secrets(k.namespace).Get(ctx, k.name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, autocert.ErrCacheMiss
+		}
+
+		return nil, fmt.Errorf("failed to get acme cache secret %s/%s: %w", k.namespace, k.name, err)
+	}
+	data, ok := secret.Data[secretDataKey(key)]
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+
+	return data, nil
+}
+
+func (k *kubeSecretCache) Put(ctx context.Context, key string, data []byte) error {
+	dataKey := secretDataKey(key)
+	secret, err := k.clientset.CoreV1().Secrets(k.namespace).Get(ctx, k.name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get acme cache secret %s/%s: %w", k.namespace, k.name, err)
+		}
+
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: k.name, Namespace: k.namespace},
+			Data:       map[string][]byte{},
+		}
+		secret.Data[dataKey] = data
+		_, err = k.clientset.CoreV1().Secrets(k.namespace).Create(ctx, secret, metav1.CreateOptions{})
+
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[dataKey] = data
+	_, err = k.clientset.CoreV1().Secrets(k.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+
+	return err
+}
+
+func (k *kubeSecretCache) Delete(ctx context.Context, key string) error {
+	secret, err := k.clientset.CoreV1().Secrets(k.namespace).Get(ctx, k.name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to get acme cache secret %s/%s: %w", k.namespace, k.name, err)
+	}
+	delete(secret.Data, secretDataKey(key))
+	_, err = k.clientset.CoreV1().Secrets(k.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+
+	return err
+}
+
+// secretDataKey sanitizes an autocert cache key so it is a valid Kubernetes
+// Secret data key (autocert keys may contain characters like ':' that
+// Secrets reject).
+func secretDataKey(key string) string {
+	return strings.NewReplacer(":", "_", "+", "_").Replace(key)
+}
+
+func splitAndTrim(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+
+	return out
+}
+
+// challengeListener opens the plain TCP listener on addr for the ACME
+// HTTP-01 challenge server. It does not serve anything itself: the caller
+// serves m's HTTPHandler on the returned listener, and m is not used here.
+func challengeListener(addr string, m *autocert.Manager) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+// kubeClientset builds an in-cluster Kubernetes clientset for the ACME
+// Secret cache. It mirrors the construction the kube backend uses for its
+// own client.
+func kubeClientset() (*kubernetes.Clientset, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build in-cluster kube config for ACME cache: %w", err)
+	}
+
+	return kubernetes.NewForConfig(cfg)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSplitAndTrim(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a,b", []string{"a", "b"}},
+		{" a , b ,, c ", []string{"a", "b", "c"}},
+	}
+	for _, c := range cases {
+		got := splitAndTrim(c.in)
+		if len(got) != len(c.want) {
+			t.Fatalf("splitAndTrim(%q) = %v, want %v", c.in, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("splitAndTrim(%q) = %v, want %v", c.in, got, c.want)
+			}
+		}
+	}
+}
+
+func TestSecretDataKey(t *testing.T) {
+	cases := map[string]string{
+		"acme_account+key": "acme_account_key",
+		"example.com":      "example.com",
+		"ca:cert+bundle":   "ca_cert_bundle",
+		"plain":            "plain",
+	}
+	for in, want := range cases {
+		if got := secretDataKey(in); got != want {
+			t.Errorf("secretDataKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestAcmeConfigCacheRejectsBothCacheOptions(t *testing.T) {
+	a := acmeConfig{cacheDir: "/tmp/acme", cacheKubeSecret: "default/smee-acme"}
+	if _, err := a.cache(context.Background()); err == nil {
+		t.Fatal("expected error when both -http-acme-cache-dir and -http-acme-cache-kube-secret are set")
+	}
+}
@@ -0,0 +1,124 @@
+// Package script renders and serves the iPXE script that chainloads a
+// machine into OSIE (HookOS) and, once OSIE has handed off to a workflow,
+// into Tink worker mode.
+package script
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/dhcp/handler"
+)
+
+// defaultOSIEProfile must match cmd/smee's defaultOSIEProfile: the name of
+// an -osie-profile entry called "default", or the name under which the
+// -osie-url value is stored in OSIEURLs when no -osie-profile is given.
+const defaultOSIEProfile = "default"
+
+// Handler renders the iPXE script served to a machine netbooting through
+// Smee.
+//
+// NOTE: this package is not present in the tree this Handler was written
+// against -- cmd/smee already constructed a script.Handler with the fields
+// Logger, Backend, OSIEURL, ExtraKernelParams, PublicSyslogFQDN,
+// TinkServerTLS and TinkServerGRPCAddr, so those fields and their meaning
+// are preserved as-is here rather than redesigned; OSIEURLs is added
+// alongside them.
+// What's implemented below is a minimal renderer satisfying that existing
+// contract; it deliberately does not guess at additional behavior (console
+// output, workflow/action data, per-host template fields, ...) that a
+// fuller implementation would need, since the shape of handler.BackendReader's
+// *data.Netboot record isn't available in this tree to implement it against
+// correctly.
+type Handler struct {
+	Logger  logr.Logger
+	Backend handler.BackendReader
+
+	// OSIEURL is the base URL used to find the OSIE (HookOS) kernel and
+	// initramfs when the request's "osie_profile" query parameter doesn't
+	// match an entry in OSIEURLs (or the parameter is absent entirely).
+	OSIEURL string
+	// OSIEURLs maps a named OSIE profile to the base URL serving that
+	// profile's kernel/initramfs. The DHCP handler's IPXEScriptURL closure
+	// tags every request with the profile it selected via the
+	// "osie_profile" query parameter; HandlerFunc looks that profile up
+	// here to decide which OSIE to serve.
+	OSIEURLs map[string]string
+
+	// ExtraKernelParams are appended verbatim to the rendered kernel
+	// cmdline and are its only arguments; cmd/smee adds the registry
+	// mirror's IMAGE_REGISTRY= arg to this slice when the mirror is enabled.
+	ExtraKernelParams []string
+
+	PublicSyslogFQDN   string
+	TinkServerTLS      bool
+	TinkServerGRPCAddr string
+}
+
+// HandlerFunc returns the http.HandlerFunc to mount at "/" to serve the
+// iPXE script.
+func (h Handler) HandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The DHCP handler advertises /<mac>/auto.ipxe, so the MAC is the
+		// path segment before the script file name.
+		mac, err := net.ParseMAC(path.Base(path.Dir(r.URL.Path)))
+		if err != nil {
+			http.Error(w, "invalid mac address in request path", http.StatusBadRequest)
+
+			return
+		}
+
+		// The backend confirms the mac is known before we render anything
+		// for it; the netboot record itself isn't consulted further here,
+		// see the Handler doc comment for why.
+		if _, _, err := h.Backend.GetByMac(r.Context(), mac); err != nil {
+			h.Logger.Error(err, "failed to look up hardware for iPXE script", "mac", mac.String())
+			http.Error(w, "failed to look up hardware", http.StatusInternalServerError)
+
+			return
+		}
+
+		osieURL := h.osieURL(r)
+		ipxeBinary := r.URL.Query().Get("ipxe_binary")
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "#!ipxe\n\n")
+		if ipxeBinary != "" {
+			fmt.Fprintf(w, "# next-stage iPXE binary for this client's architecture: %s\n", ipxeBinary)
+		}
+		fmt.Fprintf(w, "kernel %skernel %s\n", osieURL, strings.Join(h.ExtraKernelParams, " "))
+		fmt.Fprintf(w, "initrd %sinitramfs\n", osieURL)
+		if h.TinkServerGRPCAddr != "" {
+			scheme := "grpc"
+			if h.TinkServerTLS {
+				scheme = "grpcs"
+			}
+			fmt.Fprintf(w, "set tink_worker_image %s://%s\n", scheme, h.TinkServerGRPCAddr)
+		}
+		if h.PublicSyslogFQDN != "" {
+			fmt.Fprintf(w, "set syslog_ip %s\n", h.PublicSyslogFQDN)
+		}
+		fmt.Fprintf(w, "boot\n")
+	}
+}
+
+// osieURL picks the OSIE base URL for the request: the profile named by
+// the "osie_profile" query parameter, set by the DHCP handler's
+// IPXEScriptURL closure, if it matches a configured profile; otherwise the
+// "default" entry of OSIEURLs if there is one; h.OSIEURL last.
+func (h Handler) osieURL(r *http.Request) string {
+	if profile := r.URL.Query().Get("osie_profile"); profile != "" {
+		if u, ok := h.OSIEURLs[profile]; ok {
+			return u
+		}
+	}
+	if u, ok := h.OSIEURLs[defaultOSIEProfile]; ok {
+		return u
+	}
+
+	return h.OSIEURL
+}
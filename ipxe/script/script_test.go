@@ -0,0 +1,38 @@
+package script
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestHandlerOSIEURL(t *testing.T) {
+	h := Handler{
+		OSIEURL:  "http://fallback/",
+		OSIEURLs: map[string]string{"default": "http://default/", "arm64": "http://arm64/"},
+	}
+
+	cases := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"matching profile", "osie_profile=arm64", "http://arm64/"},
+		{"unknown profile falls back to default entry", "osie_profile=bogus", "http://default/"},
+		{"no profile query param falls back to default entry", "", "http://default/"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &http.Request{URL: &url.URL{RawQuery: c.query}}
+			if got := h.osieURL(r); got != c.want {
+				t.Errorf("osieURL() = %q, want %q", got, c.want)
+			}
+		})
+	}
+
+	h2 := Handler{OSIEURL: "http://fallback/"}
+	r := &http.Request{URL: &url.URL{}}
+	if got := h2.osieURL(r); got != "http://fallback/" {
+		t.Errorf("osieURL() with no OSIEURLs = %q, want %q", got, "http://fallback/")
+	}
+}